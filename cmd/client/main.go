@@ -1,11 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
 	"os"
@@ -14,6 +13,8 @@ import (
 
 	"github.com/gorilla/websocket"
 	"gopkg.in/yaml.v2"
+
+	"wstcpproxy/internal/wsproxy"
 )
 
 type Config struct {
@@ -22,35 +23,75 @@ type Config struct {
 	ReconnectDelaySec int    `yaml:"reconnect_delay_sec"`
 	MaxRetries        int    `yaml:"max_retries"`
 	ResolveCDN        bool   `yaml:"resolve_cdn"`
+
+	// Compression 控制是否与服务端协商 permessage-deflate
+	Compression      bool `yaml:"compression"`
+	CompressionLevel int  `yaml:"compression_level"`
+
+	// PingIntervalSec 为 0 时关闭心跳；ReadTimeoutSec 同时用作读/写超时和 pong 等待时间
+	PingIntervalSec int   `yaml:"ping_interval_sec"`
+	ReadTimeoutSec  int   `yaml:"read_timeout_sec"`
+	ReadLimit       int64 `yaml:"read_limit"`
+
+	// MetricsPort 非 0 时在该端口暴露 /metrics，为 0 时不启动该监听
+	MetricsPort int `yaml:"metrics_port"`
+
+	// UseMux 为 true 时，所有本地 TCP 连接共享一个持久 WebSocket 连接池，
+	// 每个连接只是池中一条复用子流，断线由会话自动重连并重放未确认的数据；
+	// 为 false 时保持旧版行为：每个本地连接独立 Dial 一次 WebSocket
+	UseMux   bool `yaml:"use_mux"`
+	PoolSize int  `yaml:"pool_size"`
 }
 
 var configFile = "client.yaml"
 
+// appLog 是 client 进程内唯一的结构化日志器，写入 ./logs/client.log
+var appLog = wsproxy.NewAppLogger(wsproxy.DefaultLogPath("client"))
+
 func main() {
 	printBanner()
 
 	cfg := loadConfig(configFile)
 
+	if cfg.MetricsPort > 0 {
+		http.Handle("/metrics", wsproxy.MetricsHandler())
+		metricsAddr := fmt.Sprintf(":%d", cfg.MetricsPort)
+		go func() {
+			appLog.Printf("[指标] /metrics 监听于 %s", metricsAddr)
+			appLog.Println("[指标]", http.ListenAndServe(metricsAddr, nil), "")
+		}()
+	}
+
 	listenAddr := fmt.Sprintf(":%d", cfg.LocalPort)
-	log.Printf("\033[34m[🎮] TCP 本地监听端口：%s\033[0m", listenAddr)
-	log.Printf("\033[36m[🌐] WebSocket 转发地址：%s\033[0m", cfg.WebSocketURL)
+	appLog.Printf("[🎮] TCP 本地监听端口：%s", listenAddr)
+	appLog.Printf("[🌐] WebSocket 转发地址：%s", cfg.WebSocketURL)
 
 	listener, err := net.Listen("tcp", listenAddr)
 	if err != nil {
-		log.Fatalf("\033[31m[错误] 无法监听本地端口：%v\033[0m\n", err)
+		appLog.Fatalf("[错误] 无法监听本地端口：%v\n", err)
 	}
 	defer listener.Close()
 
-	log.Println("\033[32m[状态] 等待 Minecraft 客户端连接...\033[0m")
+	appLog.Println("[状态] 等待 Minecraft 客户端连接...")
+
+	var pool *wsPool
+	if cfg.UseMux {
+		appLog.Printf("[连接池] 已启用 WebSocket 连接池/多路复用，池大小：%d", cfg.PoolSize)
+		pool = newWSPool(cfg, cfg.PoolSize)
+	}
 
 	for {
 		clientConn, err := listener.Accept()
 		if err != nil {
-			log.Println("\033[31m[错误] 接收连接失败：", err, "\033[0m")
+			appLog.Println("[错误] 接收连接失败：", err, "")
 			continue
 		}
-		log.Println("\033[34m[📡] Minecraft 客户端已连接，正在建立 WebSocket 通道\033[0m")
-		go handleConnection(clientConn, cfg)
+		appLog.Println("[📡] Minecraft 客户端已连接，正在建立 WebSocket 通道")
+		if pool != nil {
+			go pool.open(clientConn)
+		} else {
+			go handleConnection(clientConn, cfg)
+		}
 	}
 }
 
@@ -61,57 +102,43 @@ func handleConnection(clientConn net.Conn, cfg *Config) {
 		resolveCDNAddress(cfg.WebSocketURL)
 	}
 
+	dialer := websocket.Dialer{EnableCompression: cfg.Compression}
+
 	var ws *websocket.Conn
 	var err error
 	for attempt := 1; attempt <= cfg.MaxRetries; attempt++ {
-		ws, _, err = websocket.DefaultDialer.Dial(cfg.WebSocketURL, nil)
+		wsproxy.ReconnectAttemptsTotal.Inc()
+		ws, _, err = dialer.Dial(cfg.WebSocketURL, nil)
 		if err == nil {
-			log.Printf("\033[32m[✅] WebSocket 连接成功（第 %d 次尝试）\033[0m", attempt)
+			appLog.Printf("[✅] WebSocket 连接成功（第 %d 次尝试）", attempt)
 			break
 		}
-		log.Printf("\033[33m[重试中] WS 第 %d/%d 次连接失败：%v\033[0m", attempt, cfg.MaxRetries, err)
+		appLog.Printf("[重试中] WS 第 %d/%d 次连接失败：%v", attempt, cfg.MaxRetries, err)
 		time.Sleep(time.Duration(cfg.ReconnectDelaySec) * time.Second)
 	}
 
 	if ws == nil {
-		log.Println("\033[31m[❌] 所有尝试失败，放弃该客户端连接\033[0m")
+		appLog.Println("[❌] 所有尝试失败，放弃该客户端连接")
 		return
 	}
 	defer ws.Close()
 
-	// TCP → WebSocket
-	go func() {
-		buf := make([]byte, 4096)
-		for {
-			n, err := clientConn.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					log.Println("\033[31m[TCP → WS] 读取失败：", err, "\033[0m")
-				}
-				_ = ws.WriteMessage(websocket.CloseMessage, []byte("tcp closed"))
-				return
-			}
-			err = ws.WriteMessage(websocket.BinaryMessage, buf[:n])
-			if err != nil {
-				log.Println("\033[31m[TCP → WS] 写入失败：", err, "\033[0m")
-				return
-			}
-		}
-	}()
+	if cfg.ReadLimit > 0 {
+		ws.SetReadLimit(cfg.ReadLimit)
+	}
 
-	// WebSocket → TCP
-	for {
-		_, data, err := ws.ReadMessage()
-		if err != nil {
-			log.Println("\033[31m[WS → TCP] 读取失败：", err, "\033[0m")
-			return
-		}
-		_, err = clientConn.Write(data)
-		if err != nil {
-			log.Println("\033[31m[WS → TCP] 写入失败：", err, "\033[0m")
-			return
-		}
+	wsproxy.ActiveWSConns.Inc()
+	defer wsproxy.ActiveWSConns.Dec()
+
+	if cfg.Compression {
+		ws.SetCompressionLevel(cfg.CompressionLevel)
+	}
+
+	pc := wsproxy.PingConfig{
+		Interval: time.Duration(cfg.PingIntervalSec) * time.Second,
+		Timeout:  time.Duration(cfg.ReadTimeoutSec) * time.Second,
 	}
+	wsproxy.Pipe(context.Background(), ws, clientConn, "TCP<->WS", pc, nil, appLog)
 }
 
 func resolveCDNAddress(url string) {
@@ -128,12 +155,12 @@ func resolveCDNAddress(url string) {
 	addr := net.JoinHostPort(host, port)
 	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
 	if err != nil {
-		log.Printf("\033[33m[CDN检测] 无法连接 %s: %v\033[0m", addr, err)
+		appLog.Printf("[CDN检测] 无法连接 %s: %v", addr, err)
 		return
 	}
 	remoteAddr := conn.RemoteAddr().String()
 	conn.Close()
-	log.Printf("\033[35m[CDN检测] 使用 IP：%s\033[0m", remoteAddr)
+	appLog.Printf("[CDN检测] 使用 IP：%s", remoteAddr)
 
 	// 查询地理位置
 	ipOnly := strings.Split(remoteAddr, ":")[0]
@@ -145,7 +172,7 @@ func queryCDNGeo(ip string) {
 	client := &http.Client{Timeout: timeout}
 	resp, err := client.Get("https://ipapi.co/" + ip + "/json")
 	if err != nil {
-		log.Printf("\033[33m[CDN地理] 查询失败：%v\033[0m", err)
+		appLog.Printf("[CDN地理] 查询失败：%v", err)
 		return
 	}
 	defer resp.Body.Close()
@@ -161,10 +188,10 @@ func queryCDNGeo(ip string) {
 		Longitude float64 `json:"longitude"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		log.Printf("\033[33m[CDN地理] 解析失败：%v\033[0m", err)
+		appLog.Printf("[CDN地理] 解析失败：%v", err)
 		return
 	}
-	log.Printf("\033[36m[CDN地理] %s, %s, %s | ASN: %s | 运营商: %s | 时区: %s\033[0m",
+	appLog.Printf("[CDN地理] %s, %s, %s | ASN: %s | 运营商: %s | 时区: %s",
 		data.Country, data.Region, data.City, data.ASN, data.Org, data.Timezone)
 }
 
@@ -183,28 +210,35 @@ func extractHostname(rawURL string) string {
 
 func loadConfig(path string) *Config {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		log.Println("\033[33m[配置] 未找到配置文件，正在生成默认配置...\033[0m")
+		appLog.Println("[配置] 未找到配置文件，正在生成默认配置...")
 		defaultCfg := Config{
 			LocalPort:         25566,
 			WebSocketURL:      "ws://127.0.0.1:12381",
 			ReconnectDelaySec: 3,
 			MaxRetries:        5,
 			ResolveCDN:        true,
+			PingIntervalSec:   30,
+			ReadTimeoutSec:    60,
+			ReadLimit:         1 << 20,
+			CompressionLevel:  1,
+			MetricsPort:       0,
+			UseMux:            false,
+			PoolSize:          1,
 		}
 		data, _ := yaml.Marshal(defaultCfg)
 		_ = ioutil.WriteFile(path, data, 0644)
-		log.Println("\033[32m[配置] 已生成 client.yaml，请按需修改\033[0m")
+		appLog.Println("[配置] 已生成 client.yaml，请按需修改")
 		return &defaultCfg
 	}
 
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Fatalln("\033[31m[配置错误] 读取失败：", err, "\033[0m")
+		appLog.Fatalln("[配置错误] 读取失败：", err, "")
 	}
 
 	var cfg Config
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		log.Fatalln("\033[31m[配置错误] 解析失败：", err, "\033[0m")
+		appLog.Fatalln("[配置错误] 解析失败：", err, "")
 	}
 
 	return &cfg
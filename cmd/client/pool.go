@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"wstcpproxy/internal/wsproxy"
+)
+
+// muxResumeTokenHeader 是告诉服务端"这条新连接要接回哪个会话"的 HTTP 头，
+// 必须与 cmd/server/muxsession_registry.go 里的同名常量保持一致
+const muxResumeTokenHeader = "X-Mux-Resume-Token"
+
+// newMuxResumeToken 生成一个随机 resume token，同一个 wsSession 的每次
+// 重连都带着相同的值，使服务端能把新连接接回宽限期内保留的旧会话
+func newMuxResumeToken() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// pendingFrame 是一个已发送但尚未收到服务端 ACK 的数据帧，断线重连后
+// 需要按原序号重放
+type pendingFrame struct {
+	seq     uint64
+	payload []byte
+}
+
+// muxStreamQueueSize 是每条子流的入站 DATA 帧缓冲区大小：dispatch 只把
+// payload 放进这个队列就返回，真正写本地 TCP 的阻塞 I/O 在子流自己的
+// worker goroutine 里做，这样一个卡住的本地连接只会堆积自己的队列，
+// 不会挡住共享读循环给其他子流分发帧
+const muxStreamQueueSize = 256
+
+// muxStream 是客户端一条复用子流，对应一个本地 Minecraft 客户端的 TCP 连接
+type muxStream struct {
+	id    uint32
+	local net.Conn
+	sess  *wsSession
+
+	sendMu  sync.Mutex
+	sendSeq uint64
+	pending []pendingFrame
+
+	dataCh chan []byte   // 见 muxStreamQueueSize；由 worker 按入队顺序串行写入 local
+	done   chan struct{} // 子流销毁时关闭，让 worker 退出
+
+	closed int32 // atomic bool
+}
+
+// worker 串行处理一条子流的入站 DATA 帧，使其阻塞在 local.Write 上时
+// 只影响这一条子流，不影响 dispatch 给其他子流分发帧
+func (ms *muxStream) worker() {
+	for {
+		select {
+		case data := <-ms.dataCh:
+			if _, err := ms.local.Write(data); err != nil {
+				ms.closeLocal()
+			}
+		case <-ms.done:
+			return
+		}
+	}
+}
+
+// send 把 payload 封装成一个数据帧发出，并在 pending 中保留一份，
+// 直到收到服务端对应的 ACK 为止，供断线重连后重放
+func (ms *muxStream) send(payload []byte) error {
+	cp := make([]byte, len(payload))
+	copy(cp, payload)
+
+	ms.sendMu.Lock()
+	seq := ms.sendSeq
+	ms.sendSeq++
+	ms.pending = append(ms.pending, pendingFrame{seq: seq, payload: cp})
+	ms.sendMu.Unlock()
+
+	return ms.sess.writeFrame(wsproxy.FrameData, ms.id, seq, payload)
+}
+
+// ack 丢弃序号不大于 upTo 的已确认帧
+func (ms *muxStream) ack(upTo uint64) {
+	ms.sendMu.Lock()
+	defer ms.sendMu.Unlock()
+	i := 0
+	for ; i < len(ms.pending); i++ {
+		if ms.pending[i].seq > upTo {
+			break
+		}
+	}
+	ms.pending = ms.pending[i:]
+}
+
+// replay 在会话重新连接后，重新发送 OPEN 帧以及所有尚未被确认的数据帧，
+// 让 Minecraft 客户端在短暂的重连抖动中不丢失数据
+func (ms *muxStream) replay() {
+	ms.sendMu.Lock()
+	frames := make([]pendingFrame, len(ms.pending))
+	copy(frames, ms.pending)
+	ms.sendMu.Unlock()
+
+	if err := ms.sess.writeFrame(wsproxy.FrameOpen, ms.id, 0, nil); err != nil {
+		return
+	}
+	for _, f := range frames {
+		if err := ms.sess.writeFrame(wsproxy.FrameData, ms.id, f.seq, f.payload); err != nil {
+			return
+		}
+	}
+}
+
+func (ms *muxStream) sendClose() {
+	ms.sess.writeFrame(wsproxy.FrameClose, ms.id, 0, nil)
+}
+
+// closeLocal 关闭本地 TCP 连接；由收到服务端 CLOSE 帧或本地写入失败时调用
+func (ms *muxStream) closeLocal() {
+	if atomic.CompareAndSwapInt32(&ms.closed, 0, 1) {
+		ms.local.Close()
+	}
+}
+
+// wsSession 管理一条底层 WebSocket 连接及其上复用的所有子流。断线后
+// 在后台不断重连，重连成功时把每条活跃子流的未确认数据按序号重放，
+// 取代了旧版每个本地 TCP Accept 都重新 Dial 一次 WebSocket 的做法
+type wsSession struct {
+	cfg         *Config
+	resumeToken string // 每次重连都带着同一个值，供服务端接回宽限期内的旧会话
+
+	mu      sync.Mutex
+	conn    *websocket.Conn
+	streams map[uint32]*muxStream
+	nextID  uint32
+
+	writeMu sync.Mutex
+}
+
+func newWSSession(cfg *Config) *wsSession {
+	s := &wsSession{cfg: cfg, resumeToken: newMuxResumeToken(), streams: make(map[uint32]*muxStream)}
+	go s.run()
+	return s
+}
+
+// run 是会话的主循环：连接、收帧分发、断线后重连，持续到进程退出
+func (s *wsSession) run() {
+	for {
+		conn, err := s.connect()
+		if err != nil {
+			appLog.Printf("[连接池] 建立 WebSocket 会话失败，%ds 后重试: %v", s.cfg.ReconnectDelaySec, err)
+			time.Sleep(time.Duration(s.cfg.ReconnectDelaySec) * time.Second)
+			continue
+		}
+
+		s.mu.Lock()
+		s.conn = conn
+		streams := make([]*muxStream, 0, len(s.streams))
+		for _, st := range s.streams {
+			streams = append(streams, st)
+		}
+		s.mu.Unlock()
+
+		wsproxy.ActiveWSConns.Inc()
+		appLog.Println("[连接池] WebSocket 会话已建立")
+		for _, st := range streams {
+			st.replay()
+		}
+
+		readTimeout := time.Duration(s.cfg.ReadTimeoutSec) * time.Second
+		// 必须在 readLoop 的 ReadMessage 循环启动前注册：SetPongHandler 与
+		// ReadMessage 并发调用是未定义行为，见 wsproxy.Pipe 里的同一处理
+		conn.SetPongHandler(func(string) error {
+			if readTimeout > 0 {
+				return conn.SetReadDeadline(time.Now().Add(readTimeout))
+			}
+			return nil
+		})
+
+		var keepaliveCtx context.Context
+		var cancelKeepalive context.CancelFunc
+		if s.cfg.PingIntervalSec > 0 {
+			keepaliveCtx, cancelKeepalive = context.WithCancel(context.Background())
+			go wsproxy.Keepalive(keepaliveCtx, conn, wsproxy.PingConfig{
+				Interval: time.Duration(s.cfg.PingIntervalSec) * time.Second,
+				Timeout:  readTimeout,
+			})
+		}
+
+		s.readLoop(conn)
+		if cancelKeepalive != nil {
+			cancelKeepalive()
+		}
+		wsproxy.ActiveWSConns.Dec()
+
+		s.mu.Lock()
+		if s.conn == conn {
+			s.conn = nil
+		}
+		s.mu.Unlock()
+		appLog.Println("[连接池] WebSocket 会话断开，准备重连")
+	}
+}
+
+func (s *wsSession) connect() (*websocket.Conn, error) {
+	dialer := websocket.Dialer{
+		EnableCompression: s.cfg.Compression,
+		Subprotocols:      []string{wsproxy.MuxSubprotocol},
+	}
+
+	header := http.Header{}
+	if s.resumeToken != "" {
+		header.Set(muxResumeTokenHeader, s.resumeToken)
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= s.cfg.MaxRetries; attempt++ {
+		wsproxy.ReconnectAttemptsTotal.Inc()
+		conn, _, err := dialer.Dial(s.cfg.WebSocketURL, header)
+		if err == nil {
+			if s.cfg.Compression {
+				conn.SetCompressionLevel(s.cfg.CompressionLevel)
+			}
+			if s.cfg.ReadLimit > 0 {
+				conn.SetReadLimit(s.cfg.ReadLimit)
+			}
+			return conn, nil
+		}
+		lastErr = err
+		appLog.Printf("[连接池] 第 %d/%d 次连接失败: %v", attempt, s.cfg.MaxRetries, err)
+		time.Sleep(time.Duration(s.cfg.ReconnectDelaySec) * time.Second)
+	}
+	return nil, lastErr
+}
+
+func (s *wsSession) readLoop(conn *websocket.Conn) {
+	for {
+		mt, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if mt != websocket.BinaryMessage {
+			continue
+		}
+		t, streamID, seq, payload, err := wsproxy.DecodeFrame(data)
+		if err != nil {
+			appLog.Printf("[连接池] 解析帧失败: %v", err)
+			continue
+		}
+		s.dispatch(t, streamID, seq, payload)
+	}
+}
+
+// dispatch 只做帧的路由和排队，不做任何可能阻塞的 I/O：DATA 帧的
+// local.Write 扔给该子流自己的 worker goroutine，这样一个卡住的本地
+// 连接不会挡住这条 WebSocket 连接上其他子流的帧分发
+func (s *wsSession) dispatch(t wsproxy.FrameType, streamID uint32, seq uint64, payload []byte) {
+	s.mu.Lock()
+	st, ok := s.streams[streamID]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	switch t {
+	case wsproxy.FrameData:
+		st.dataCh <- payload
+	case wsproxy.FrameClose:
+		st.closeLocal()
+	case wsproxy.FrameAck:
+		st.ack(seq)
+	}
+}
+
+// writeFrame 把一帧写到当前底层连接；会话正在重连（conn 为 nil）时
+// 返回错误，调用方（muxStream.send）把该帧留在 pending 中等待下次重放
+func (s *wsSession) writeFrame(t wsproxy.FrameType, streamID uint32, seq uint64, payload []byte) error {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn == nil {
+		return fmt.Errorf("mux: 会话未连接")
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return conn.WriteMessage(websocket.BinaryMessage, wsproxy.EncodeFrame(t, streamID, seq, payload))
+}
+
+// openStream 为一个新的本地 TCP 连接分配子流 ID，发送 OPEN 帧，
+// 然后把本地连接读到的数据不断封装成 DATA 帧转发，直至该连接关闭
+func (s *wsSession) openStream(local net.Conn) {
+	defer local.Close()
+
+	s.mu.Lock()
+	s.nextID++
+	id := s.nextID
+	st := &muxStream{
+		id:     id,
+		local:  local,
+		sess:   s,
+		dataCh: make(chan []byte, muxStreamQueueSize),
+		done:   make(chan struct{}),
+	}
+	s.streams[id] = st
+	s.mu.Unlock()
+	go st.worker()
+
+	defer func() {
+		close(st.done)
+		s.mu.Lock()
+		delete(s.streams, id)
+		s.mu.Unlock()
+	}()
+
+	wsproxy.ActiveTCPConns.Inc()
+	defer wsproxy.ActiveTCPConns.Dec()
+
+	if err := s.writeFrame(wsproxy.FrameOpen, id, 0, nil); err != nil {
+		appLog.Printf("[连接池] 子流 %d 发送 OPEN 帧失败，等待会话重连后自动重放: %v", id, err)
+	}
+
+	buf := make([]byte, wsproxy.PipeBufferSize)
+	for {
+		n, err := local.Read(buf)
+		if n > 0 {
+			if sendErr := st.send(buf[:n]); sendErr != nil {
+				appLog.Printf("[连接池] 子流 %d 发送失败: %v", id, sendErr)
+			}
+		}
+		if err != nil {
+			st.sendClose()
+			return
+		}
+	}
+}
+
+// wsPool 维护一组持久化的 WebSocket 会话，新的本地 TCP 连接按轮询分配到
+// 其中一个会话的新子流，取代了旧版每个连接独立 Dial 一次 WebSocket 的做法
+type wsPool struct {
+	sessions []*wsSession
+	next     uint32 // atomic 轮询计数
+}
+
+// newWSPool 创建一个包含 size 条持久 WebSocket 会话的连接池；size <= 0 时按 1 处理
+func newWSPool(cfg *Config, size int) *wsPool {
+	if size <= 0 {
+		size = 1
+	}
+	p := &wsPool{}
+	for i := 0; i < size; i++ {
+		p.sessions = append(p.sessions, newWSSession(cfg))
+	}
+	return p
+}
+
+// open 把一个本地 TCP 连接接入池中下一个会话的新子流，阻塞直至该子流结束
+func (p *wsPool) open(local net.Conn) {
+	idx := atomic.AddUint32(&p.next, 1) % uint32(len(p.sessions))
+	p.sessions[idx].openStream(local)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthConfig 描述 handleWS 在升级为 WebSocket 之后执行的鉴权规则。
+// 各字段均为空/零值时表示不启用对应的检查
+type AuthConfig struct {
+	Token      string   `yaml:"token"`       // 通过 Sec-WebSocket-Protocol 或 Authorization 校验的共享密钥
+	HMACSecret string   `yaml:"hmac_secret"` // 用于校验 ?expires=&sig= 形式的带过期时间签名 URL
+	AllowCIDRs []string `yaml:"allow_cidrs"`
+	DenyCIDRs  []string `yaml:"deny_cidrs"`
+}
+
+// authenticate 依次执行 IP 名单、共享密钥、签名 URL 检查，
+// 任意一项配置了且未通过都会返回非 nil error
+func authenticate(r *http.Request, cfg *AuthConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	if err := checkIPList(r, cfg); err != nil {
+		return err
+	}
+	if cfg.Token != "" {
+		if err := checkToken(r, cfg.Token); err != nil {
+			return err
+		}
+	}
+	if cfg.HMACSecret != "" {
+		if err := checkSignedURL(r, cfg.HMACSecret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requireAuth 用 authenticate 包装一个 http.HandlerFunc，鉴权失败时直接
+// 返回 403 而不调用 next；用于 /stats、/dashboard 等不经过 handleWS 的
+// 管理端点，使它们与 WebSocket 入口共享同一套鉴权规则
+func requireAuth(cfg *AuthConfig, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := authenticate(r, cfg); err != nil {
+			appLog.Println("[鉴权失败]", err)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// checkIPList 校验客户端 IP 是否满足 CIDR 允许/拒绝名单
+func checkIPList(r *http.Request, cfg *AuthConfig) error {
+	if len(cfg.AllowCIDRs) == 0 && len(cfg.DenyCIDRs) == 0 {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("auth: 无法解析客户端 IP: %s", r.RemoteAddr)
+	}
+	for _, cidr := range cfg.DenyCIDRs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return fmt.Errorf("auth: IP %s 命中拒绝名单 %s", ip, cidr)
+		}
+	}
+	if len(cfg.AllowCIDRs) == 0 {
+		return nil
+	}
+	for _, cidr := range cfg.AllowCIDRs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("auth: IP %s 不在允许名单中", ip)
+}
+
+// checkToken 校验 Sec-WebSocket-Protocol 或 Authorization: Bearer 中携带的共享密钥
+func checkToken(r *http.Request, token string) error {
+	if proto := r.Header.Get("Sec-WebSocket-Protocol"); proto != "" {
+		for _, p := range strings.Split(proto, ",") {
+			if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(p)), []byte(token)) == 1 {
+				return nil
+			}
+		}
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		provided := strings.TrimPrefix(auth, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return fmt.Errorf("auth: 缺少或错误的共享密钥")
+}
+
+// checkSignedURL 校验形如 ?expires=<unix秒>&sig=<hex hmac-sha256> 的签名 URL，
+// 签名覆盖请求路径与 expires 参数
+func checkSignedURL(r *http.Request, secret string) error {
+	q := r.URL.Query()
+	expiresStr := q.Get("expires")
+	sig := q.Get("sig")
+	if expiresStr == "" || sig == "" {
+		return fmt.Errorf("auth: 缺少签名参数")
+	}
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		return fmt.Errorf("auth: expires 参数非法: %v", err)
+	}
+	if time.Now().Unix() > expires {
+		return fmt.Errorf("auth: 签名已过期")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(r.URL.Path + "?expires=" + expiresStr))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(sig), []byte(expected)) != 1 {
+		return fmt.Errorf("auth: 签名校验失败")
+	}
+	return nil
+}
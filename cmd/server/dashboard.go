@@ -0,0 +1,152 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"wstcpproxy/internal/wsproxy"
+)
+
+//go:embed dashboard.html
+var dashboardFS embed.FS
+
+// sessionView 是下发给 dashboard 前端的只读快照
+type sessionView struct {
+	ID         string    `json:"id"`
+	ClientIP   string    `json:"client_ip"`
+	Target     string    `json:"target"`
+	StartedAt  time.Time `json:"started_at"`
+	BytesToTCP int64     `json:"bytes_to_tcp"`
+	BytesToWS  int64     `json:"bytes_to_ws"`
+}
+
+// session 跟踪一条正在转发的连接；stats 由 pipe() 原子更新，
+// kill 关闭底层连接以结束会话，会驱动 pipe() 尽快返回
+type session struct {
+	id        string
+	clientIP  string
+	target    string
+	startedAt time.Time
+	stats     *wsproxy.PipeStats
+	kill      func()
+}
+
+// sessionRegistry 维护当前所有活跃会话，供 /dashboard/ws 展示、
+// /dashboard/kill 按 ID 关闭使用
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	nextID   int64
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{sessions: make(map[string]*session)}
+}
+
+// add 注册一个新会话并返回其 ID
+func (sr *sessionRegistry) add(clientIP, target string, stats *wsproxy.PipeStats, kill func()) string {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.nextID++
+	id := fmt.Sprintf("%d", sr.nextID)
+	sr.sessions[id] = &session{
+		id:        id,
+		clientIP:  clientIP,
+		target:    target,
+		startedAt: time.Now(),
+		stats:     stats,
+		kill:      kill,
+	}
+	return id
+}
+
+func (sr *sessionRegistry) remove(id string) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	delete(sr.sessions, id)
+}
+
+// kill 按 ID 查找会话并关闭其连接，返回是否找到
+func (sr *sessionRegistry) kill(id string) bool {
+	sr.mu.Lock()
+	s, ok := sr.sessions[id]
+	sr.mu.Unlock()
+	if !ok {
+		return false
+	}
+	s.kill()
+	return true
+}
+
+func (sr *sessionRegistry) list() []sessionView {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	out := make([]sessionView, 0, len(sr.sessions))
+	for _, s := range sr.sessions {
+		var toTCP, toWS int64
+		if s.stats != nil {
+			toTCP = atomic.LoadInt64(&s.stats.BytesToTCP)
+			toWS = atomic.LoadInt64(&s.stats.BytesToWS)
+		}
+		out = append(out, sessionView{
+			ID:         s.id,
+			ClientIP:   s.clientIP,
+			Target:     s.target,
+			StartedAt:  s.startedAt,
+			BytesToTCP: toTCP,
+			BytesToWS:  toWS,
+		})
+	}
+	return out
+}
+
+var dashboardUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// registerDashboard 挂载三个端点：/dashboard 静态页面、
+// /dashboard/ws 实时推送会话列表、/dashboard/kill 按 ID 关闭会话。
+// /dashboard/kill 是写端点，三者都经 requireAuth 复用 cfg.Auth 的鉴权规则，
+// 避免任何能连到这个端口的客户端都能窥探或终止他人的会话
+func registerDashboard(sr *sessionRegistry, cfg *AuthConfig) {
+	http.HandleFunc("/dashboard", requireAuth(cfg, func(w http.ResponseWriter, r *http.Request) {
+		data, err := dashboardFS.ReadFile("dashboard.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(data)
+	}))
+
+	http.HandleFunc("/dashboard/kill", requireAuth(cfg, func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		if sr.kill(id) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.Error(w, "未知的会话 ID", http.StatusNotFound)
+	}))
+
+	http.HandleFunc("/dashboard/ws", requireAuth(cfg, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := dashboardUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := conn.WriteJSON(sr.list()); err != nil {
+				return
+			}
+		}
+	}))
+}
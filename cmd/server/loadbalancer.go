@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"wstcpproxy/internal/wsproxy"
+)
+
+// BackendConfig 描述负载均衡池中的一个 TCP 后端
+type BackendConfig struct {
+	Host   string `yaml:"host"`
+	Port   int    `yaml:"port"`
+	Weight int    `yaml:"weight"`
+}
+
+// LBStrategy 是选择后端的策略
+type LBStrategy string
+
+const (
+	StrategyRoundRobin       LBStrategy = "round_robin"
+	StrategyWeighted         LBStrategy = "weighted"
+	StrategyLeastConnections LBStrategy = "least_connections"
+)
+
+// backend 是负载均衡器内部维护的一个后端及其运行时状态
+type backend struct {
+	addr    string
+	weight  int
+	healthy int32 // atomic bool
+	conns   int64 // atomic，当前活跃连接数
+}
+
+func (b *backend) isHealthy() bool {
+	return atomic.LoadInt32(&b.healthy) == 1
+}
+
+func (b *backend) setHealthy(v bool) {
+	var i int32
+	if v {
+		i = 1
+	}
+	atomic.StoreInt32(&b.healthy, i)
+}
+
+func (b *backend) acquire() { atomic.AddInt64(&b.conns, 1) }
+func (b *backend) release() { atomic.AddInt64(&b.conns, -1) }
+
+// LoadBalancer 在多个 TCP 后端之间分发新连接，并周期性做健康检查，
+// 故障后端会被摘出轮转，恢复后自动加回
+type LoadBalancer struct {
+	strategy LBStrategy
+	backends []*backend
+
+	mu      sync.Mutex // 保护轮转游标
+	rrIndex int
+}
+
+// NewLoadBalancer 按配置构造负载均衡器；targets 为空时返回 nil，
+// 调用方应据此判断是否走单目标的旧逻辑
+func NewLoadBalancer(targets []BackendConfig, strategy LBStrategy) *LoadBalancer {
+	if len(targets) == 0 {
+		return nil
+	}
+	if strategy == "" {
+		strategy = StrategyRoundRobin
+	}
+	lb := &LoadBalancer{strategy: strategy}
+	for _, t := range targets {
+		w := t.Weight
+		if w <= 0 {
+			w = 1
+		}
+		b := &backend{addr: fmt.Sprintf("%s:%d", t.Host, t.Port), weight: w}
+		b.setHealthy(true)
+		wsproxy.BackendHealthy.WithLabelValues(b.addr).Set(1)
+		lb.backends = append(lb.backends, b)
+	}
+	return lb
+}
+
+// Next 按配置的策略选出一个健康的后端
+func (lb *LoadBalancer) Next() (*backend, error) {
+	healthy := make([]*backend, 0, len(lb.backends))
+	for _, b := range lb.backends {
+		if b.isHealthy() {
+			healthy = append(healthy, b)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, fmt.Errorf("loadbalancer: 没有可用的后端")
+	}
+
+	switch lb.strategy {
+	case StrategyWeighted:
+		return lb.pickWeighted(healthy), nil
+	case StrategyLeastConnections:
+		return lb.pickLeastConnections(healthy), nil
+	default:
+		return lb.pickRoundRobin(healthy), nil
+	}
+}
+
+func (lb *LoadBalancer) pickRoundRobin(healthy []*backend) *backend {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	b := healthy[lb.rrIndex%len(healthy)]
+	lb.rrIndex++
+	return b
+}
+
+func (lb *LoadBalancer) pickWeighted(healthy []*backend) *backend {
+	total := 0
+	for _, b := range healthy {
+		total += b.weight
+	}
+	lb.mu.Lock()
+	idx := lb.rrIndex
+	lb.rrIndex++
+	lb.mu.Unlock()
+
+	target := idx % total
+	for _, b := range healthy {
+		if target < b.weight {
+			return b
+		}
+		target -= b.weight
+	}
+	return healthy[0]
+}
+
+func (lb *LoadBalancer) pickLeastConnections(healthy []*backend) *backend {
+	best := healthy[0]
+	for _, b := range healthy[1:] {
+		if atomic.LoadInt64(&b.conns) < atomic.LoadInt64(&best.conns) {
+			best = b
+		}
+	}
+	return best
+}
+
+// StartHealthChecks 周期性地对每个后端做一次 TCP 拨号探活，
+// 失败即摘除出轮转，之后恢复健康会自动重新加入
+func (lb *LoadBalancer) StartHealthChecks(interval, timeout time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			for _, b := range lb.backends {
+				healthyNow := probeTCP(b.addr, timeout)
+				if healthyNow != b.isHealthy() {
+					b.setHealthy(healthyNow)
+					if healthyNow {
+						appLog.Printf("[健康检查] 后端 %s 恢复", b.addr)
+						wsproxy.BackendHealthy.WithLabelValues(b.addr).Set(1)
+					} else {
+						appLog.Printf("[健康检查] 后端 %s 失败，已从轮转中摘除", b.addr)
+						wsproxy.BackendHealthy.WithLabelValues(b.addr).Set(0)
+					}
+				}
+			}
+		}
+	}()
+}
+
+func probeTCP(addr string, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// BackendStat 是 /stats 接口返回的单个后端状态
+type BackendStat struct {
+	Addr        string `json:"addr"`
+	Healthy     bool   `json:"healthy"`
+	Connections int64  `json:"connections"`
+}
+
+// StatsHandler 返回一个以 JSON 形式输出当前每个后端状态的 HTTP handler
+func (lb *LoadBalancer) StatsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := make([]BackendStat, 0, len(lb.backends))
+		for _, b := range lb.backends {
+			stats = append(stats, BackendStat{
+				Addr:        b.addr,
+				Healthy:     b.isHealthy(),
+				Connections: atomic.LoadInt64(&b.conns),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopkg.in/yaml.v2"
+
+	"wstcpproxy/internal/wsproxy"
+)
+
+// Route 描述虚拟主机路由的转发目标
+type Route struct {
+	TCPHost string `yaml:"tcp_host"`
+	TCPPort int    `yaml:"tcp_port"`
+}
+
+// 配置结构
+type Config struct {
+	WSPort  int    `yaml:"ws_port"`
+	TCPHost string `yaml:"tcp_host"`
+	TCPPort int    `yaml:"tcp_port"`
+
+	// Routes 按 Minecraft 握手包中的 server address（小写）路由到不同的后端，
+	// 未命中时回退到默认的 TCPHost/TCPPort
+	Routes map[string]Route `yaml:"routes"`
+
+	// ProxyProtocol 为 "v2" 时，在转发握手字节前向 TCP 后端发送
+	// HAProxy PROXY protocol v2 header，携带真实客户端地址
+	ProxyProtocol string `yaml:"proxy_protocol"`
+
+	// Compression 控制是否与客户端协商 permessage-deflate
+	Compression      bool `yaml:"compression"`
+	CompressionLevel int  `yaml:"compression_level"`
+
+	// PingIntervalSec 为 0 时关闭心跳；ReadTimeoutSec 同时用作读/写超时和 pong 等待时间
+	PingIntervalSec int   `yaml:"ping_interval_sec"`
+	ReadTimeoutSec  int   `yaml:"read_timeout_sec"`
+	ReadLimit       int64 `yaml:"read_limit"`
+
+	// TLS 配置后，WS 服务以 wss:// 方式监听
+	TLS TLSConfig `yaml:"tls"`
+
+	// Auth 为空时不做任何鉴权，与历史行为保持一致
+	Auth AuthConfig `yaml:"auth"`
+
+	// TCPTargets 非空时启用负载均衡，取代单一的 TCPHost/TCPPort
+	// （命中 Routes 的虚拟主机路由仍然优先）
+	TCPTargets             []BackendConfig `yaml:"tcp_targets"`
+	LBStrategy             LBStrategy      `yaml:"lb_strategy"`
+	HealthCheckIntervalSec int             `yaml:"health_check_interval_sec"`
+	HealthCheckTimeoutSec  int             `yaml:"health_check_timeout_sec"`
+
+	// MuxResumeGraceSec 控制 mux 会话断线重连的宽限期：WebSocket 断开后
+	// 这么多秒内，客户端带着同一个 resume token 重新连接可以接回原会话，
+	// 其间已打开的后端 TCP 子流保持存活。<= 0 时使用内置默认值。
+	MuxResumeGraceSec int `yaml:"mux_resume_grace_sec"`
+}
+
+var defaultConfig = Config{
+	WSPort:                 8080,
+	TCPHost:                "localhost",
+	TCPPort:                25565,
+	PingIntervalSec:        30,
+	ReadTimeoutSec:         60,
+	ReadLimit:              1 << 20,
+	CompressionLevel:       1,
+	HealthCheckIntervalSec: 10,
+	HealthCheckTimeoutSec:  2,
+	MuxResumeGraceSec:      defaultMuxResumeGraceSec,
+}
+
+// appLog 是 server 进程内唯一的结构化日志器，写入 ./logs/server.log
+var appLog = wsproxy.NewAppLogger(wsproxy.DefaultLogPath("server"))
+
+// resolveRoute 按握手包中的 server address 查找虚拟主机路由，
+// 第二个返回值表示是否命中
+func resolveRoute(cfg *Config, serverAddress string) (string, bool) {
+	route, ok := cfg.Routes[strings.ToLower(serverAddress)]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%s:%d", route.TCPHost, route.TCPPort), true
+}
+
+// wsByteReader 把按消息收发的 WebSocket 连接包装成一个普通的 io.Reader，
+// 这样就能复用标准库式的缓冲解析逻辑（例如逐字节读取 Minecraft VarInt），
+// 未被消费的消息剩余字节会保留到下一次 Read
+type wsByteReader struct {
+	conn *websocket.Conn
+	buf  []byte
+}
+
+func (r *wsByteReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		mt, data, err := r.conn.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		if mt != websocket.BinaryMessage {
+			continue
+		}
+		r.buf = data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// 自动生成默认配置
+func writeDefaultConfig(filename string) error {
+	data, err := yaml.Marshal(&defaultConfig)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// 读取配置文件
+func loadConfig(filename string) (*Config, error) {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		appLog.Println("[配置] 未找到 config.yaml，正在生成默认配置...")
+		if err := writeDefaultConfig(filename); err != nil {
+			return nil, fmt.Errorf("无法创建默认配置: %v", err)
+		}
+		appLog.Println("[配置] 默认配置已生成，请检查 config.yaml")
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置失败: %v", err)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("解析配置失败: %v", err)
+	}
+	return &cfg, nil
+}
+
+// 获取公网 IP
+func getPublicIP(url string) string {
+	client := &http.Client{Timeout: 4 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "获取失败：" + err.Error()
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "读取失败：" + err.Error()
+	}
+	return string(body)
+}
+
+// 打印本地 + 公网 IP
+func printIPInfo() {
+	appLog.Println("[本地IP]")
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+			ip := ipNet.IP.String()
+			if ipNet.IP.To4() != nil {
+				appLog.Println("  IPv4:", ip)
+			} else if ipNet.IP.To16() != nil {
+				appLog.Println("  IPv6:", ip)
+			}
+		}
+	}
+	appLog.Println("[公网IP]")
+	appLog.Println("  IPv4:", getPublicIP("https://api.ipify.org"))
+	appLog.Println("  IPv6:", getPublicIP("https://api64.ipify.org"))
+}
+
+// 控制台 Banner
+func printBanner() {
+	fmt.Println("\033[36m")
+	fmt.Println(`  __  __ ______ _____  _____  _____             _     _`)
+	fmt.Println(` |  \/  |  ____|  __ \|  __ \|  __ \     /\    | |   (_)`)
+	fmt.Println(` | \  / | |__  | |__) | |__) | |__) |   /  \   | |__  _  ___`)
+	fmt.Println(` | |\/| |  __| |  ___/|  ___/|  _  /   / /\ \  | '_ \| |/ __|`)
+	fmt.Println(` | |  | | |____| |    | |    | | \ \  / ____ \ | |_) | | (__`)
+	fmt.Println(` |_|  |_|______|_|    |_|    |_|  \_\/_/    \_\|_.__/|_|\___|`)
+	fmt.Println("\033[35m         🐾 MeowParadise - WebSocket ⇄ Minecraft Proxy")
+	fmt.Println("         🌐 https://mzyd.work | https://hhnlab.cn")
+	fmt.Println("\033[0m===============================================================")
+}
+
+// 端口占用自动重试；tlsCfg 非空且配置完整时以 wss:// 方式监听
+func startServerWithFallback(startPort int, maxTries int, handler http.Handler, tlsCfg TLSConfig) (int, error) {
+	for i := 0; i < maxTries; i++ {
+		port := startPort + i
+		addr := fmt.Sprintf(":%d", port)
+		server := &http.Server{Addr: addr, Handler: handler}
+
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			appLog.Printf("[端口占用] %d 被占用，尝试下一个...", port)
+			continue
+		}
+
+		scheme := "ws"
+		if tlsCfg.Enabled() {
+			tlsLn, err := wrapTLS(ln, tlsCfg)
+			if err != nil {
+				ln.Close()
+				return 0, err
+			}
+			ln = tlsLn
+			scheme = "wss"
+		}
+
+		go func() {
+			appLog.Printf("[启动成功] WebSocket 服务监听 %s://0.0.0.0:%d\n", scheme, port)
+			appLog.Fatal(server.Serve(ln))
+		}()
+		return port, nil
+	}
+	return 0, fmt.Errorf("端口全部被占用")
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin:  func(r *http.Request) bool { return true },
+	Subprotocols: []string{wsproxy.MuxSubprotocol},
+}
+
+func handleWS(w http.ResponseWriter, r *http.Request, cfg *Config, lb *LoadBalancer, sr *sessionRegistry, muxReg *muxSessionRegistry) {
+	wsConn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		wsproxy.UpgradeFailuresTotal.Inc()
+		appLog.Println("[WS] 升级失败:", err)
+		return
+	}
+	defer wsConn.Close()
+	appLog.Println("[WS] 新的连接")
+
+	wsproxy.ActiveWSConns.Inc()
+	defer wsproxy.ActiveWSConns.Dec()
+
+	if err := authenticate(r, &cfg.Auth); err != nil {
+		appLog.Println("[鉴权失败]", err)
+		wsConn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()))
+		return
+	}
+
+	if cfg.Compression {
+		wsConn.SetCompressionLevel(cfg.CompressionLevel)
+	}
+	if cfg.ReadLimit > 0 {
+		wsConn.SetReadLimit(cfg.ReadLimit)
+	}
+
+	// 客户端协商了 mux 子协议时，整条 WebSocket 连接承载多条复用子流，
+	// 转发逻辑与下面的单流直通路径完全不同，交给专门的 handleMuxSession。
+	// 带着与上次相同 resume token 重连时，muxReg.acquire 会把这条新连接
+	// 接到宽限期内保留下来的旧会话上，已打开的后端 TCP 子流不受影响。
+	if wsConn.Subprotocol() == wsproxy.MuxSubprotocol {
+		token := r.Header.Get(muxResumeTokenHeader)
+		sess := muxReg.acquire(token, cfg, lb, sr)
+		sess.attachConn(wsConn)
+
+		// mux 连接不经过 wsproxy.Pipe，读超时/心跳得自己装一遍：否则协商了
+		// mux 子协议后一直不发帧的客户端会永久挂住这个 goroutine、
+		// ActiveWSConns 计数和底层 socket，空闲的 NAT 会话也会被静默断开
+		readTimeout := time.Duration(cfg.ReadTimeoutSec) * time.Second
+		// 必须在 handleMuxSession 的 ReadMessage 循环启动前注册：SetPongHandler
+		// 与 ReadMessage 并发调用是未定义行为，见 wsproxy.Pipe 里的同一处理
+		wsConn.SetPongHandler(func(string) error {
+			if readTimeout > 0 {
+				return wsConn.SetReadDeadline(time.Now().Add(readTimeout))
+			}
+			return nil
+		})
+		if readTimeout > 0 {
+			wsConn.SetReadDeadline(time.Now().Add(readTimeout))
+		}
+
+		var cancelKeepalive context.CancelFunc
+		if cfg.PingIntervalSec > 0 {
+			var keepaliveCtx context.Context
+			keepaliveCtx, cancelKeepalive = context.WithCancel(context.Background())
+			go wsproxy.Keepalive(keepaliveCtx, wsConn, wsproxy.PingConfig{
+				Interval: time.Duration(cfg.PingIntervalSec) * time.Second,
+				Timeout:  readTimeout,
+			})
+		}
+
+		handleMuxSession(wsConn, sess)
+		if cancelKeepalive != nil {
+			cancelKeepalive()
+		}
+		muxReg.release(token, sess)
+		return
+	}
+
+	// 先从流中窥探 Minecraft 握手包，用于虚拟主机路由；
+	// 窥探过程中读取到的字节会原样保留，稍后回放给 TCP 后端
+	wr := &wsByteReader{conn: wsConn}
+	var peeked bytes.Buffer
+	tee := io.TeeReader(wr, &peeked)
+
+	tcpTarget := fmt.Sprintf("%s:%d", cfg.TCPHost, cfg.TCPPort)
+	routedByHostname := false
+
+	// Pipe 启动前心跳尚未建立，此处没有东西在驱动读超时：不设置的话，升级成功后
+	// 一直不发握手包的客户端会把这个 goroutine、ActiveWSConns 计数和底层 socket
+	// 永久挂住。握手解析完成、Pipe 接管连接后清除，交还给 Keepalive 的 pong 续期
+	if cfg.ReadTimeoutSec > 0 {
+		wsConn.SetReadDeadline(time.Now().Add(time.Duration(cfg.ReadTimeoutSec) * time.Second))
+	}
+
+	var firstByte [1]byte
+	if _, err := io.ReadFull(tee, firstByte[:]); err != nil {
+		appLog.Println("[MC] 读取首字节失败:", err)
+		return
+	}
+
+	if IsLegacyPing(firstByte[0]) {
+		appLog.Println("[MC] 收到旧版 Legacy Ping (0xFE)")
+	} else if hs, err := ParseHandshake(io.MultiReader(bytes.NewReader(firstByte[:]), tee)); err != nil {
+		appLog.Println("[MC] 握手包解析失败，按原始字节流转发:", err, "")
+	} else if route, ok := resolveRoute(cfg, hs.ServerAddress); ok {
+		tcpTarget = route
+		routedByHostname = true
+		stateName := "status"
+		if hs.NextState == NextStateLogin {
+			stateName = "login"
+		}
+		appLog.Printf("[MC] 握手: host=%s state=%s -> %s", hs.ServerAddress, stateName, tcpTarget)
+	}
+
+	// 握手窥探已结束，清除临时读超时，后续读超时改由 Pipe/Keepalive 接管
+	wsConn.SetReadDeadline(time.Time{})
+
+	var chosen *backend
+	if !routedByHostname && lb != nil {
+		b, err := lb.Next()
+		if err != nil {
+			appLog.Println("[LB] 选择后端失败:", err)
+			wsConn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
+			return
+		}
+		tcpTarget = b.addr
+		chosen = b
+	}
+
+	tcpConn, err := net.Dial("tcp", tcpTarget)
+	if err != nil {
+		wsproxy.DialFailuresTotal.Inc()
+		appLog.Println("[TCP] 连接失败:", err)
+		wsConn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
+		return
+	}
+	defer tcpConn.Close()
+	appLog.Println("[TCP] 已连接到", tcpTarget, "")
+
+	wsproxy.ActiveTCPConns.Inc()
+	defer wsproxy.ActiveTCPConns.Dec()
+
+	if chosen != nil {
+		chosen.acquire()
+		defer chosen.release()
+	}
+
+	if cfg.ProxyProtocol == "v2" {
+		header, err := buildProxyProtocolV2(wsConn.RemoteAddr(), tcpConn.RemoteAddr())
+		if err != nil {
+			appLog.Println("[ProxyProtocol] 构造 header 失败:", err, "")
+		} else if _, err := tcpConn.Write(header); err != nil {
+			appLog.Println("[ProxyProtocol] 写入失败:", err)
+		}
+	}
+
+	if _, err := tcpConn.Write(peeked.Bytes()); err != nil {
+		appLog.Println("[TCP] 回放握手字节失败:", err)
+		return
+	}
+	if len(wr.buf) > 0 {
+		if _, err := tcpConn.Write(wr.buf); err != nil {
+			appLog.Println("[TCP] 回放缓冲字节失败:", err)
+			return
+		}
+		wr.buf = nil
+	}
+
+	pc := wsproxy.PingConfig{
+		Interval: time.Duration(cfg.PingIntervalSec) * time.Second,
+		Timeout:  time.Duration(cfg.ReadTimeoutSec) * time.Second,
+	}
+
+	var stats *wsproxy.PipeStats
+	var sessionID string
+	if sr != nil {
+		stats = &wsproxy.PipeStats{}
+		sessionID = sr.add(wsConn.RemoteAddr().String(), tcpTarget, stats, func() {
+			wsConn.Close()
+			tcpConn.Close()
+		})
+		defer sr.remove(sessionID)
+	}
+
+	wsproxy.Pipe(context.Background(), wsConn, tcpConn, "WS<->TCP", pc, stats, appLog)
+	appLog.Println("[连接关闭]")
+}
+
+func waitForExit() {
+	fmt.Println("\n\033[33m按下 Enter 键退出程序...\033[0m")
+	bufio.NewReader(os.Stdin).ReadBytes('\n')
+}
+
+func run() {
+	printBanner()
+
+	config, err := loadConfig("config.yaml")
+	if err != nil {
+		appLog.Fatalln("[配置错误]", err)
+	}
+	upgrader.EnableCompression = config.Compression
+	printIPInfo()
+
+	lb := NewLoadBalancer(config.TCPTargets, config.LBStrategy)
+	if lb != nil {
+		healthInterval := time.Duration(config.HealthCheckIntervalSec) * time.Second
+		healthTimeout := time.Duration(config.HealthCheckTimeoutSec) * time.Second
+		if healthTimeout <= 0 {
+			healthTimeout = 2 * time.Second
+		}
+		lb.StartHealthChecks(healthInterval, healthTimeout)
+		http.HandleFunc("/stats", requireAuth(&config.Auth, lb.StatsHandler()))
+	}
+
+	sr := newSessionRegistry()
+	registerDashboard(sr, &config.Auth)
+	http.Handle("/metrics", requireAuth(&config.Auth, wsproxy.MetricsHandler().ServeHTTP))
+
+	muxReg := newMuxSessionRegistry(config.MuxResumeGraceSec)
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		handleWS(w, r, config, lb, sr, muxReg)
+	})
+
+	port, err := startServerWithFallback(config.WSPort, 20, http.DefaultServeMux, config.TLS)
+	if err != nil {
+		appLog.Fatalln("[错误] 所有端口都无法监听：", err)
+	}
+	appLog.Printf("[监听端口] 实际使用端口：%d\n", port)
+	select {} // 阻塞主线程
+}
+
+func main() {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("\033[31m[崩溃] 程序异常退出：\033[0m", r)
+		}
+		waitForExit()
+	}()
+
+	run()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Minecraft 握手包中的 next state 取值
+const (
+	NextStateStatus = 1
+	NextStateLogin  = 2
+)
+
+// LegacyPingPacketID 是旧版(1.6 及更早)Server List Ping 的标志字节，
+// 这种 ping 不走 VarInt 长度前缀的新式协议。
+const LegacyPingPacketID = 0xFE
+
+const (
+	maxVarIntBytes  = 5    // VarInt 最多 5 字节（32 位）
+	maxHandshakeLen = 2048 // 握手包正常情况下很小，超过此值视为异常/攻击
+	maxAddressLen   = 255  // server address 字段（含 SRV/FML 后缀）上限
+)
+
+// Handshake 对应 Minecraft 协议的握手包 (state=0, packet ID 0x00)
+type Handshake struct {
+	ProtocolVersion int32
+	ServerAddress   string
+	ServerPort      uint16
+	NextState       int32
+}
+
+// readVarInt 从 r 中读取一个 VarInt
+func readVarInt(r io.Reader) (int32, error) {
+	var result int32
+	var numRead int
+	var b [1]byte
+	for {
+		if numRead >= maxVarIntBytes {
+			return 0, fmt.Errorf("minecraft: VarInt 超过 %d 字节，数据可能已损坏", maxVarIntBytes)
+		}
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		result |= int32(b[0]&0x7F) << (7 * numRead)
+		numRead++
+		if b[0]&0x80 == 0 {
+			break
+		}
+	}
+	return result, nil
+}
+
+// readString 读取一个以 VarInt 长度为前缀的 UTF-8 字符串
+func readString(r io.Reader, maxLen int32) (string, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return "", err
+	}
+	if length < 0 || length > maxLen {
+		return "", fmt.Errorf("minecraft: 字符串长度非法: %d", length)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// IsLegacyPing 判断首字节是否是旧版(pre-netty) Server List Ping (0xFE)
+func IsLegacyPing(firstByte byte) bool {
+	return firstByte == LegacyPingPacketID
+}
+
+// ParseHandshake 从一段位于数据包起始处（VarInt 长度字段之前）的字节流中
+// 解析出握手包。失败时返回的 error 会说明是哪一部分不合法。
+func ParseHandshake(r io.Reader) (*Handshake, error) {
+	pktLen, err := readVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("minecraft: 读取包长度失败: %w", err)
+	}
+	if pktLen <= 0 || pktLen > maxHandshakeLen {
+		return nil, fmt.Errorf("minecraft: 握手包长度非法: %d", pktLen)
+	}
+
+	body := make([]byte, pktLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("minecraft: 读取包体失败: %w", err)
+	}
+	br := bytes.NewReader(body)
+
+	packetID, err := readVarInt(br)
+	if err != nil {
+		return nil, fmt.Errorf("minecraft: 读取包 ID 失败: %w", err)
+	}
+	if packetID != 0x00 {
+		return nil, fmt.Errorf("minecraft: 期望握手包 ID 0x00，实际收到 0x%02X", packetID)
+	}
+
+	protocolVersion, err := readVarInt(br)
+	if err != nil {
+		return nil, fmt.Errorf("minecraft: 读取协议版本失败: %w", err)
+	}
+	serverAddress, err := readString(br, maxAddressLen)
+	if err != nil {
+		return nil, fmt.Errorf("minecraft: 读取 server address 失败: %w", err)
+	}
+	var portBuf [2]byte
+	if _, err := io.ReadFull(br, portBuf[:]); err != nil {
+		return nil, fmt.Errorf("minecraft: 读取 server port 失败: %w", err)
+	}
+	nextState, err := readVarInt(br)
+	if err != nil {
+		return nil, fmt.Errorf("minecraft: 读取 next state 失败: %w", err)
+	}
+	if nextState != NextStateStatus && nextState != NextStateLogin {
+		return nil, fmt.Errorf("minecraft: next state 非法: %d", nextState)
+	}
+
+	return &Handshake{
+		ProtocolVersion: protocolVersion,
+		ServerAddress:   serverAddress,
+		ServerPort:      binary.BigEndian.Uint16(portBuf[:]),
+		NextState:       nextState,
+	}, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// writeVarInt 是 readVarInt 的逆过程，仅供测试构造数据包使用
+func writeVarInt(buf *bytes.Buffer, v int32) {
+	u := uint32(v)
+	for {
+		b := byte(u & 0x7F)
+		u >>= 7
+		if u != 0 {
+			b |= 0x80
+		}
+		buf.WriteByte(b)
+		if u == 0 {
+			return
+		}
+	}
+}
+
+func TestReadVarIntRoundTrip(t *testing.T) {
+	cases := []int32{0, 1, 127, 128, 255, 300, 2097151, 1<<31 - 1}
+	for _, v := range cases {
+		var buf bytes.Buffer
+		writeVarInt(&buf, v)
+		got, err := readVarInt(&buf)
+		if err != nil {
+			t.Fatalf("readVarInt(%d) 失败: %v", v, err)
+		}
+		if got != v {
+			t.Errorf("readVarInt 往返失败: 写入 %d，读回 %d", v, got)
+		}
+	}
+}
+
+func TestReadVarIntOverflow(t *testing.T) {
+	// 5 字节全部带续传位，超过 maxVarIntBytes，应当报错而不是死循环/越界
+	buf := bytes.NewReader([]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x01})
+	if _, err := readVarInt(buf); err == nil {
+		t.Fatal("readVarInt 应当在超过 5 字节时返回错误")
+	}
+}
+
+func buildHandshakePacket(protocolVersion int32, serverAddress string, port uint16, nextState int32) []byte {
+	var body bytes.Buffer
+	writeVarInt(&body, 0x00) // packet ID
+	writeVarInt(&body, protocolVersion)
+	writeVarInt(&body, int32(len(serverAddress)))
+	body.WriteString(serverAddress)
+	var portBuf [2]byte
+	binary.BigEndian.PutUint16(portBuf[:], port)
+	body.Write(portBuf[:])
+	writeVarInt(&body, nextState)
+
+	var pkt bytes.Buffer
+	writeVarInt(&pkt, int32(body.Len()))
+	pkt.Write(body.Bytes())
+	return pkt.Bytes()
+}
+
+func TestParseHandshake(t *testing.T) {
+	data := buildHandshakePacket(763, "play.example.com", 25565, NextStateLogin)
+
+	hs, err := ParseHandshake(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ParseHandshake 失败: %v", err)
+	}
+	if hs.ProtocolVersion != 763 {
+		t.Errorf("ProtocolVersion = %d，期望 763", hs.ProtocolVersion)
+	}
+	if hs.ServerAddress != "play.example.com" {
+		t.Errorf("ServerAddress = %q，期望 play.example.com", hs.ServerAddress)
+	}
+	if hs.ServerPort != 25565 {
+		t.Errorf("ServerPort = %d，期望 25565", hs.ServerPort)
+	}
+	if hs.NextState != NextStateLogin {
+		t.Errorf("NextState = %d，期望 %d", hs.NextState, NextStateLogin)
+	}
+}
+
+func TestParseHandshakeInvalidNextState(t *testing.T) {
+	data := buildHandshakePacket(763, "play.example.com", 25565, 99)
+	if _, err := ParseHandshake(bytes.NewReader(data)); err == nil {
+		t.Fatal("ParseHandshake 应当拒绝非法的 next state")
+	}
+}
+
+func TestParseHandshakeOversizedLength(t *testing.T) {
+	var pkt bytes.Buffer
+	writeVarInt(&pkt, maxHandshakeLen+1)
+	if _, err := ParseHandshake(&pkt); err == nil {
+		t.Fatal("ParseHandshake 应当拒绝超过 maxHandshakeLen 的包长度")
+	}
+}
+
+func TestIsLegacyPing(t *testing.T) {
+	if !IsLegacyPing(LegacyPingPacketID) {
+		t.Error("IsLegacyPing(0xFE) 应当为 true")
+	}
+	if IsLegacyPing(0x00) {
+		t.Error("IsLegacyPing(0x00) 应当为 false")
+	}
+}
+
+func TestParseHandshakeLongAddressRejected(t *testing.T) {
+	data := buildHandshakePacket(763, strings.Repeat("a", maxAddressLen+1), 25565, NextStateStatus)
+	if _, err := ParseHandshake(bytes.NewReader(data)); err == nil {
+		t.Fatal("ParseHandshake 应当拒绝超长的 server address")
+	}
+}
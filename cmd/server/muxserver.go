@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"wstcpproxy/internal/wsproxy"
+)
+
+// muxStreamQueueSize 是每条子流的入站 DATA 帧缓冲区大小：dispatch 只把帧
+// 放进这个队列就返回，真正的 net.Dial/tcp.Write 在各自子流的 worker
+// goroutine 里做，这样一条后端慢/卡住的子流只会堆积自己的队列，不会
+// 挡住共享读循环给其他子流分发帧
+const muxStreamQueueSize = 256
+
+// recvFrame 是排队等待某条子流 worker 处理的一个入站 DATA 帧
+type recvFrame struct {
+	seq     uint64
+	payload []byte
+}
+
+// muxServerStream 是服务端看到的一条被复用的子流，对应客户端一次本地
+// TCP Accept；每条子流独立拨号 TCP 后端，生命周期与旧版单流 handleWS
+// 基本一致，只是数据通过 DATA 帧而不是独占的 WebSocket 连接传输。
+//
+// 注意：子流的目标解析仅走 TCPTargets 负载均衡或默认 TCPHost/TCPPort，
+// 不支持按 Minecraft 握手包做虚拟主机路由 —— 握手包此时已经被封装在
+// DATA 帧里，要按 hostname 路由需要在拨号前先解码首个 DATA 帧，这超出
+// 了本次改动的范围。
+type muxServerStream struct {
+	id  uint32
+	tcp net.Conn
+
+	recvMu  sync.Mutex
+	nextSeq uint64 // 下一个期望处理的数据帧序号，用于丢弃重放产生的重复帧
+
+	dataCh chan recvFrame // 见 muxStreamQueueSize；由 worker 按入队顺序串行处理
+	done   chan struct{}  // 子流销毁时关闭，让 worker 退出
+}
+
+// worker 串行处理一条子流的入站 DATA 帧，使其阻塞在 tcp.Write 上时
+// 只影响这一条子流，不影响 dispatch 给其他子流分发帧
+func (st *muxServerStream) worker(sess *muxServerSession) {
+	for {
+		select {
+		case f := <-st.dataCh:
+			st.recv(sess, f.seq, f.payload)
+		case <-st.done:
+			return
+		}
+	}
+}
+
+// muxServerSession 对应客户端一条 resume token 背后的逻辑会话。底层
+// WebSocket 连接断开后，会话本身连同其 TCP 子流并不会立即销毁，而是
+// 保留 mux_resume_grace_sec 秒等待同一 token 的重连（见 muxSessionRegistry），
+// 这样短暂的网络抖动不会杀死后端的 Minecraft 游戏连接
+type muxServerSession struct {
+	cfg *Config
+	lb  *LoadBalancer
+	sr  *sessionRegistry
+
+	mu           sync.Mutex
+	wsConn       *websocket.Conn // 为 nil 表示正处于断线宽限期，尚未有新连接接入
+	streams      map[uint32]*muxServerStream
+	pendingOpens map[uint32]bool // 正在拨号、尚未进入 streams 的 streamID，防止重复 OPEN 并发拨号两次
+
+	writeMu sync.Mutex
+	closed  int32 // atomic bool，宽限期耗尽、会话被彻底销毁后置位
+}
+
+func newMuxServerSession(cfg *Config, lb *LoadBalancer, sr *sessionRegistry) *muxServerSession {
+	return &muxServerSession{
+		cfg:          cfg,
+		lb:           lb,
+		sr:           sr,
+		streams:      make(map[uint32]*muxServerStream),
+		pendingOpens: make(map[uint32]bool),
+	}
+}
+
+// attachConn 把会话绑定到一条新的 WebSocket 连接上：首次建立或宽限期内
+// 重连都走这里，已经打开的 TCP 子流不受影响
+func (sess *muxServerSession) attachConn(wsConn *websocket.Conn) {
+	sess.mu.Lock()
+	sess.wsConn = wsConn
+	sess.mu.Unlock()
+}
+
+func (sess *muxServerSession) isClosed() bool {
+	return atomic.LoadInt32(&sess.closed) == 1
+}
+
+func (sess *muxServerSession) streamCount() int {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return len(sess.streams)
+}
+
+// handleMuxSession 处理一条已经（重新）绑定到 sess 的 WebSocket 连接，
+// 按帧分发到各自的子流，直到该连接断开；会话本身的生命周期由调用方
+// （handleWS + muxSessionRegistry）管理
+func handleMuxSession(wsConn *websocket.Conn, sess *muxServerSession) {
+	for {
+		mt, data, err := wsConn.ReadMessage()
+		if err != nil {
+			appLog.Println("[Mux] 连接断开:", err)
+			return
+		}
+		if mt != websocket.BinaryMessage {
+			continue
+		}
+		t, streamID, seq, payload, err := wsproxy.DecodeFrame(data)
+		if err != nil {
+			appLog.Printf("[Mux] 解析帧失败: %v", err)
+			continue
+		}
+		sess.dispatch(t, streamID, seq, payload)
+	}
+}
+
+// dispatch 只做帧的路由和排队，不做任何可能阻塞的 I/O：OPEN 的拨号和
+// DATA 的 tcp.Write 都扔给各自子流的 goroutine，这样一条卡住的子流不会
+// 挡住这条 WebSocket 连接上其他子流的帧分发
+func (sess *muxServerSession) dispatch(t wsproxy.FrameType, streamID uint32, seq uint64, payload []byte) {
+	switch t {
+	case wsproxy.FrameOpen:
+		go sess.openStream(streamID)
+	case wsproxy.FrameData:
+		sess.mu.Lock()
+		st, ok := sess.streams[streamID]
+		sess.mu.Unlock()
+		if ok {
+			st.dataCh <- recvFrame{seq: seq, payload: payload}
+		}
+	case wsproxy.FrameClose:
+		sess.mu.Lock()
+		st, ok := sess.streams[streamID]
+		delete(sess.streams, streamID)
+		sess.mu.Unlock()
+		if ok {
+			st.tcp.Close()
+		}
+	}
+}
+
+// openStream 拨号一个新的 TCP 后端并开始把它的数据转发成 DATA 帧；
+// 对同一个 streamID 重复收到的 OPEN（客户端重连重放）会被忽略 ——
+// 宽限期内重连时这正是我们想要的：已经在跑的子流不会被重新拨号。
+// 由 dispatch 以 goroutine 方式调用，因此这里的 net.Dial 不会挡住
+// 其他子流的帧分发；pendingOpens 防止同一 streamID 的并发 OPEN 在
+// 拨号完成、写入 streams 之前重复拨号
+func (sess *muxServerSession) openStream(streamID uint32) {
+	sess.mu.Lock()
+	if _, exists := sess.streams[streamID]; exists || sess.pendingOpens[streamID] {
+		sess.mu.Unlock()
+		return
+	}
+	sess.pendingOpens[streamID] = true
+	sess.mu.Unlock()
+	defer func() {
+		sess.mu.Lock()
+		delete(sess.pendingOpens, streamID)
+		sess.mu.Unlock()
+	}()
+
+	target := fmt.Sprintf("%s:%d", sess.cfg.TCPHost, sess.cfg.TCPPort)
+	var chosen *backend
+	if sess.lb != nil {
+		b, err := sess.lb.Next()
+		if err == nil {
+			target = b.addr
+			chosen = b
+		}
+	}
+
+	tcp, err := net.Dial("tcp", target)
+	if err != nil {
+		wsproxy.DialFailuresTotal.Inc()
+		appLog.Printf("[Mux] 子流 %d 拨号 %s 失败: %v", streamID, target, err)
+		sess.writeFrame(wsproxy.FrameClose, streamID, 0, nil)
+		return
+	}
+	if chosen != nil {
+		chosen.acquire()
+	}
+
+	if sess.cfg.ProxyProtocol == "v2" {
+		sess.mu.Lock()
+		wsConn := sess.wsConn
+		sess.mu.Unlock()
+		if wsConn != nil {
+			header, err := buildProxyProtocolV2(wsConn.RemoteAddr(), tcp.RemoteAddr())
+			if err != nil {
+				appLog.Printf("[Mux] 子流 %d 构造 PROXY protocol header 失败: %v", streamID, err)
+			} else if _, err := tcp.Write(header); err != nil {
+				appLog.Printf("[Mux] 子流 %d 写入 PROXY protocol header 失败: %v", streamID, err)
+			}
+		}
+	}
+
+	st := &muxServerStream{
+		id:     streamID,
+		tcp:    tcp,
+		dataCh: make(chan recvFrame, muxStreamQueueSize),
+		done:   make(chan struct{}),
+	}
+	sess.mu.Lock()
+	sess.streams[streamID] = st
+	sess.mu.Unlock()
+	go st.worker(sess)
+
+	wsproxy.ActiveTCPConns.Inc()
+	appLog.Printf("[Mux] 子流 %d 已连接到 %s", streamID, target)
+
+	var remoteAddr string
+	if sess.sr != nil {
+		sess.mu.Lock()
+		if sess.wsConn != nil {
+			remoteAddr = sess.wsConn.RemoteAddr().String()
+		}
+		sess.mu.Unlock()
+	}
+	var sessionID string
+	if sess.sr != nil {
+		sessionID = sess.sr.add(remoteAddr, target, nil, func() { tcp.Close() })
+	}
+
+	go func() {
+		defer func() {
+			tcp.Close()
+			close(st.done)
+			if chosen != nil {
+				chosen.release()
+			}
+			wsproxy.ActiveTCPConns.Dec()
+			sess.mu.Lock()
+			delete(sess.streams, streamID)
+			sess.mu.Unlock()
+			sess.writeFrame(wsproxy.FrameClose, streamID, 0, nil)
+			if sess.sr != nil {
+				sess.sr.remove(sessionID)
+			}
+		}()
+
+		var seq uint64
+		buf := make([]byte, wsproxy.PipeBufferSize)
+		for {
+			n, err := tcp.Read(buf)
+			if n > 0 {
+				payload := append([]byte(nil), buf[:n]...)
+				for {
+					if werr := sess.writeFrame(wsproxy.FrameData, streamID, seq, payload); werr == nil {
+						break
+					}
+					// 当前没有可用的 WebSocket 连接：很可能正处于断线宽限期，
+					// 稍等片刻等客户端重连，而不是直接杀掉这条后端 TCP 连接
+					if sess.isClosed() {
+						return
+					}
+					time.Sleep(200 * time.Millisecond)
+				}
+				seq++
+				wsproxy.BytesTotal.WithLabelValues("tcp_to_ws").Add(float64(n))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// recv 处理一个到达的数据帧：按序号丢弃客户端重连后重放的重复帧，
+// 写入本地 TCP 后立即回 ACK 以便客户端裁剪其重放缓冲区。由子流自己的
+// worker goroutine 串行调用，因此这里的 tcp.Write 只会阻塞这一条子流
+func (st *muxServerStream) recv(sess *muxServerSession, seq uint64, payload []byte) {
+	st.recvMu.Lock()
+	if seq < st.nextSeq {
+		st.recvMu.Unlock()
+		sess.writeFrame(wsproxy.FrameAck, st.id, seq, nil)
+		return
+	}
+	st.nextSeq = seq + 1
+	st.recvMu.Unlock()
+
+	if _, err := st.tcp.Write(payload); err != nil {
+		appLog.Printf("[Mux] 子流 %d 写入 TCP 失败: %v", st.id, err)
+		return
+	}
+	wsproxy.BytesTotal.WithLabelValues("ws_to_tcp").Add(float64(len(payload)))
+	sess.writeFrame(wsproxy.FrameAck, st.id, seq, nil)
+}
+
+// writeFrame 把一帧写到当前绑定的 WebSocket 连接；宽限期内 wsConn 为 nil
+// 时返回错误，调用方（openStream 的转发 goroutine）据此判断要不要重试
+func (sess *muxServerSession) writeFrame(t wsproxy.FrameType, streamID uint32, seq uint64, payload []byte) error {
+	sess.mu.Lock()
+	conn := sess.wsConn
+	sess.mu.Unlock()
+	if conn == nil {
+		return fmt.Errorf("mux: 会话未连接")
+	}
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	return conn.WriteMessage(websocket.BinaryMessage, wsproxy.EncodeFrame(t, streamID, seq, payload))
+}
+
+// closeAll 彻底销毁会话：关闭所有子流的 TCP 连接，并让仍在重试写入的
+// 转发 goroutine 退出。由 muxSessionRegistry 在宽限期到期后调用
+func (sess *muxServerSession) closeAll() {
+	atomic.StoreInt32(&sess.closed, 1)
+
+	sess.mu.Lock()
+	streams := make([]*muxServerStream, 0, len(sess.streams))
+	for _, st := range sess.streams {
+		streams = append(streams, st)
+	}
+	sess.mu.Unlock()
+	for _, st := range streams {
+		st.tcp.Close()
+	}
+}
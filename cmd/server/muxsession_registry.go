@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultMuxResumeGraceSec 是未在配置中设置 mux_resume_grace_sec 时使用的
+// 默认宽限期：WebSocket 断开后这么久之内，同一 resume token 重新连接
+// 可以接回原来的 muxServerSession，其间已打开的后端 TCP 子流保持存活
+const defaultMuxResumeGraceSec = 20
+
+// muxResumeTokenHeader 是客户端在握手请求中携带 resume token 的 HTTP 头，
+// 同一个客户端 wsSession 的多次重连会一直带着相同的值
+const muxResumeTokenHeader = "X-Mux-Resume-Token"
+
+// muxSessionRegistry 按客户端提供的 resume token 保存尚在宽限期内的
+// muxServerSession，使断线重连能接回原会话而不是从空连接重新开始
+type muxSessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*muxSessionEntry
+	graceDur time.Duration
+}
+
+type muxSessionEntry struct {
+	sess  *muxServerSession
+	timer *time.Timer
+}
+
+func newMuxSessionRegistry(graceSec int) *muxSessionRegistry {
+	if graceSec <= 0 {
+		graceSec = defaultMuxResumeGraceSec
+	}
+	return &muxSessionRegistry{
+		sessions: make(map[string]*muxSessionEntry),
+		graceDur: time.Duration(graceSec) * time.Second,
+	}
+}
+
+// acquire 返回 token 对应的会话：如果宽限期内已存在就复用（取消其销毁
+// 定时器），否则新建一个。token 为空字符串时表示客户端未声明 resume
+// token（旧客户端或显式禁用），每次都新建一次性会话
+func (reg *muxSessionRegistry) acquire(token string, cfg *Config, lb *LoadBalancer, sr *sessionRegistry) *muxServerSession {
+	if token == "" {
+		return newMuxServerSession(cfg, lb, sr)
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if entry, ok := reg.sessions[token]; ok {
+		if entry.timer != nil {
+			entry.timer.Stop()
+		}
+		return entry.sess
+	}
+
+	sess := newMuxServerSession(cfg, lb, sr)
+	reg.sessions[token] = &muxSessionEntry{sess: sess}
+	return sess
+}
+
+// release 在一条 WebSocket 连接断开后调用：把会话标记为"无连接"并启动
+// 宽限期定时器，定时器到期后才真正关闭会话持有的所有 TCP 子流
+func (reg *muxSessionRegistry) release(token string, sess *muxServerSession) {
+	sess.attachConn(nil)
+
+	if token == "" {
+		sess.closeAll()
+		return
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	entry, ok := reg.sessions[token]
+	if !ok || entry.sess != sess {
+		return
+	}
+	entry.timer = time.AfterFunc(reg.graceDur, func() {
+		reg.mu.Lock()
+		delete(reg.sessions, token)
+		reg.mu.Unlock()
+		sess.closeAll()
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// PROXY protocol v2 固定签名，详见 haproxy 的 PROXY-PROTOCOL.txt
+var proxyProtoV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+const (
+	ppv2VerCmd     = 0x21 // 高 4 位版本号(2)，低 4 位 PROXY 命令
+	ppv2FamilyTCP4 = 0x11 // AF_INET  + STREAM
+	ppv2FamilyTCP6 = 0x21 // AF_INET6 + STREAM
+)
+
+// buildProxyProtocolV2 构造一个 PROXY protocol v2 header，
+// 用于在连接 TCP 后端前携带真实客户端（WebSocket 对端）的 IP/端口，
+// 否则 Velocity/BungeeCord 之类的后端只会看到代理自身的本地地址。
+func buildProxyProtocolV2(src, dst net.Addr) ([]byte, error) {
+	srcTCP, ok := src.(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("proxyproto: 不支持的源地址类型 %T", src)
+	}
+	dstTCP, ok := dst.(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("proxyproto: 不支持的目标地址类型 %T", dst)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(proxyProtoV2Signature)
+	buf.WriteByte(ppv2VerCmd)
+
+	if srcIP4 := srcTCP.IP.To4(); srcIP4 != nil && dstTCP.IP.To4() != nil {
+		buf.WriteByte(ppv2FamilyTCP4)
+		binary.Write(&buf, binary.BigEndian, uint16(12))
+		buf.Write(srcIP4)
+		buf.Write(dstTCP.IP.To4())
+		binary.Write(&buf, binary.BigEndian, uint16(srcTCP.Port))
+		binary.Write(&buf, binary.BigEndian, uint16(dstTCP.Port))
+	} else {
+		buf.WriteByte(ppv2FamilyTCP6)
+		binary.Write(&buf, binary.BigEndian, uint16(36))
+		buf.Write(srcTCP.IP.To16())
+		buf.Write(dstTCP.IP.To16())
+		binary.Write(&buf, binary.BigEndian, uint16(srcTCP.Port))
+		binary.Write(&buf, binary.BigEndian, uint16(dstTCP.Port))
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,126 @@
+// Package wsproxy 包含 client 和 server 两个二进制共用的转发核心：
+// 结构化日志、Prometheus 指标、WS<->TCP 管道以及多路复用帧编解码。
+package wsproxy
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultMaxLogSize = 10 * 1024 * 1024 // 10MB
+
+// rotatingFile 是一个按大小滚动的 io.Writer：写入后若超过 maxSize，
+// 就把当前文件重命名为带时间戳的备份，再新建一个同名文件继续写
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+}
+
+func newRotatingFile(path string, maxSize int64) (*rotatingFile, error) {
+	if maxSize <= 0 {
+		maxSize = defaultMaxLogSize
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &rotatingFile{path: path, maxSize: maxSize, file: f, size: info.Size()}, nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	backup := fmt.Sprintf("%s.%s", r.path, time.Now().Format("20060102-150405"))
+	if err := os.Rename(r.path, backup); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.file = f
+	r.size = 0
+	return nil
+}
+
+// AppLogger 包装 slog，对外暴露一组与标准库 log 包同名的方法，
+// 这样调用方不需要改变调用习惯，但实际写出的是 JSON 结构化日志：
+// 同时落盘到滚动文件并回显到控制台
+type AppLogger struct {
+	l *slog.Logger
+}
+
+// NewAppLogger 创建一个写入 logPath 的结构化日志器；logPath 所在目录
+// 不存在或不可写时退化为仅输出到控制台
+func NewAppLogger(logPath string) *AppLogger {
+	var writer io.Writer = os.Stdout
+	if rf, err := newRotatingFile(logPath, defaultMaxLogSize); err != nil {
+		slog.Default().Warn("无法打开日志文件，仅输出到控制台", "path", logPath, "err", err)
+	} else {
+		writer = io.MultiWriter(rf, os.Stdout)
+	}
+	handler := slog.NewJSONHandler(writer, &slog.HandlerOptions{Level: slog.LevelInfo})
+	return &AppLogger{l: slog.New(handler)}
+}
+
+func (a *AppLogger) Println(args ...any) {
+	a.l.Info(fmt.Sprintln(args...))
+}
+
+func (a *AppLogger) Printf(format string, args ...any) {
+	a.l.Info(fmt.Sprintf(format, args...))
+}
+
+func (a *AppLogger) Fatal(args ...any) {
+	a.l.Error(fmt.Sprint(args...))
+	os.Exit(1)
+}
+
+func (a *AppLogger) Fatalln(args ...any) {
+	a.l.Error(fmt.Sprintln(args...))
+	os.Exit(1)
+}
+
+func (a *AppLogger) Fatalf(format string, args ...any) {
+	a.l.Error(fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// DefaultLogPath 返回 name（二进制名，如 "client"/"server"）对应的
+// 默认结构化日志文件路径：logs/<name>.log
+func DefaultLogPath(name string) string {
+	return filepath.Join("logs", name+".log")
+}
@@ -0,0 +1,59 @@
+package wsproxy
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// 进程级 Prometheus 指标，server 和 client 两个二进制共用同一套定义，
+// 各自只会用到其中与自己相关的部分
+var (
+	ActiveWSConns = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "wstcp_active_ws_connections",
+		Help: "当前活跃的 WebSocket 连接数",
+	})
+	ActiveTCPConns = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "wstcp_active_tcp_connections",
+		Help: "当前活跃的 TCP 连接数",
+	})
+	BytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wstcp_bytes_total",
+		Help: "按方向统计的累计转发字节数",
+	}, []string{"direction"})
+	UpgradeFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "wstcp_ws_upgrade_failures_total",
+		Help: "WebSocket 升级失败的累计次数",
+	})
+	DialFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "wstcp_tcp_dial_failures_total",
+		Help: "TCP 拨号失败的累计次数",
+	})
+	ReconnectAttemptsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "wstcp_client_reconnect_attempts_total",
+		Help: "客户端 WebSocket 重连尝试的累计次数",
+	})
+	BackendHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wstcp_backend_healthy",
+		Help: "负载均衡后端的健康状态 (1=健康, 0=不健康)",
+	}, []string{"backend"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		ActiveWSConns,
+		ActiveTCPConns,
+		BytesTotal,
+		UpgradeFailuresTotal,
+		DialFailuresTotal,
+		ReconnectAttemptsTotal,
+		BackendHealthy,
+	)
+}
+
+// MetricsHandler 返回 /metrics Prometheus 端点的 handler；是否挂载、
+// 挂在哪个 mux、是否需要鉴权由调用方决定
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,57 @@
+package wsproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// MuxSubprotocol 是客户端请求多路复用会话时协商的 WebSocket 子协议，
+// 服务端据此决定是走旧的单流直通路径还是 handleMuxSession
+const MuxSubprotocol = "mcwsproxy.mux.v1"
+
+// FrameType 标识一个多路复用帧的类型
+type FrameType byte
+
+const (
+	FrameOpen  FrameType = 1 // 新建一条子流（客户端 -> 服务端）
+	FrameData  FrameType = 2 // 子流数据，带序号用于 ACK 与断线重放去重
+	FrameClose FrameType = 3 // 关闭一条子流（任意一端 -> 对端）
+	FrameAck   FrameType = 4 // 确认已处理到某序号的数据帧（服务端 -> 客户端）
+)
+
+const maxFrameHeaderLen = 1 + binary.MaxVarintLen32 + binary.MaxVarintLen64
+
+// EncodeFrame 编码一个多路复用帧：[type 1字节][streamID varint][seq varint][payload]。
+// OPEN/CLOSE 帧不使用 seq，固定传 0；ACK 帧没有 payload，seq 表示被确认到的序号。
+func EncodeFrame(t FrameType, streamID uint32, seq uint64, payload []byte) []byte {
+	buf := make([]byte, maxFrameHeaderLen+len(payload))
+	buf[0] = byte(t)
+	n := 1
+	n += binary.PutUvarint(buf[n:], uint64(streamID))
+	n += binary.PutUvarint(buf[n:], seq)
+	n += copy(buf[n:], payload)
+	return buf[:n]
+}
+
+// DecodeFrame 解析 EncodeFrame 写出的帧
+func DecodeFrame(data []byte) (t FrameType, streamID uint32, seq uint64, payload []byte, err error) {
+	if len(data) < 1 {
+		return 0, 0, 0, nil, fmt.Errorf("mux: 帧为空")
+	}
+	t = FrameType(data[0])
+	r := bytes.NewReader(data[1:])
+
+	sid, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, 0, 0, nil, fmt.Errorf("mux: 读取 streamID 失败: %w", err)
+	}
+	seqv, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, 0, 0, nil, fmt.Errorf("mux: 读取 seq 失败: %w", err)
+	}
+
+	remaining := r.Len()
+	payload = data[len(data)-remaining:]
+	return t, uint32(sid), seqv, payload, nil
+}
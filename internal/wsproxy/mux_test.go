@@ -0,0 +1,61 @@
+package wsproxy
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeDecodeFrameRoundTrip(t *testing.T) {
+	cases := []struct {
+		name    string
+		t       FrameType
+		id      uint32
+		seq     uint64
+		payload []byte
+	}{
+		{"open", FrameOpen, 1, 0, nil},
+		{"data", FrameData, 42, 12345, []byte("hello minecraft")},
+		{"close", FrameClose, 7, 0, nil},
+		{"ack", FrameAck, 1000000, 1<<63 - 1, nil},
+		{"empty payload", FrameData, 2, 5, []byte{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			encoded := EncodeFrame(c.t, c.id, c.seq, c.payload)
+			gotType, gotID, gotSeq, gotPayload, err := DecodeFrame(encoded)
+			if err != nil {
+				t.Fatalf("DecodeFrame 失败: %v", err)
+			}
+			if gotType != c.t {
+				t.Errorf("type = %v，期望 %v", gotType, c.t)
+			}
+			if gotID != c.id {
+				t.Errorf("streamID = %d，期望 %d", gotID, c.id)
+			}
+			if gotSeq != c.seq {
+				t.Errorf("seq = %d，期望 %d", gotSeq, c.seq)
+			}
+			if len(c.payload) == 0 {
+				if len(gotPayload) != 0 {
+					t.Errorf("payload = %v，期望为空", gotPayload)
+				}
+			} else if !bytes.Equal(gotPayload, c.payload) {
+				t.Errorf("payload = %v，期望 %v", gotPayload, c.payload)
+			}
+		})
+	}
+}
+
+func TestDecodeFrameEmptyData(t *testing.T) {
+	if _, _, _, _, err := DecodeFrame(nil); err == nil {
+		t.Fatal("DecodeFrame 应当拒绝空数据")
+	}
+}
+
+func TestDecodeFrameTruncated(t *testing.T) {
+	// 只有 type 字节，streamID/seq 的 varint 都缺失
+	if _, _, _, _, err := DecodeFrame([]byte{byte(FrameData)}); err == nil {
+		t.Fatal("DecodeFrame 应当拒绝截断的帧")
+	}
+}
@@ -0,0 +1,156 @@
+package wsproxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// PipeBufferSize 是 Pipe 转发缓冲区的大小，也供 mux 子流转发复用同样的
+// 大小以保持行为一致
+const PipeBufferSize = 32 * 1024
+
+// pipeBufPool 复用 TCP->WS 方向的转发缓冲区，避免每次转发都重新分配
+var pipeBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, PipeBufferSize)
+		return &buf
+	},
+}
+
+// PingConfig 描述一条 WebSocket 连接的读写超时与心跳参数
+type PingConfig struct {
+	Interval time.Duration // 0 表示关闭心跳
+	Timeout  time.Duration // 读/写超时，也用作 pong 等待时间
+}
+
+// PipeStats 累计一条 Pipe 双向转发的字节数，供 dashboard 展示单个会话的流量；
+// 为 nil 时表示调用方不关心这些统计
+type PipeStats struct {
+	BytesToTCP int64 // ws -> tcp
+	BytesToWS  int64 // tcp -> ws
+}
+
+// Pipe 在 ws 与 tcp 之间做双向转发，是 server 和 client 两个程序共用的
+// 核心转发逻辑。任意一侧读取或写入出错都会关闭另一侧的连接，驱动 Pipe
+// 尽快返回，调用方负责最终 Close 两个连接。label 仅用于日志前缀，
+// logger 用调用方自己的 AppLogger，这样日志仍落到各自的 logs/<name>.log
+func Pipe(ctx context.Context, ws *websocket.Conn, tcp net.Conn, label string, pc PingConfig, stats *PipeStats, logger *AppLogger) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// SetPongHandler 必须在 WS 读取 goroutine 启动前注册一次：gorilla/websocket
+	// 把它归为"读方法"，与另一个 goroutine 并发调用 ReadMessage 是未定义行为，
+	// 所以不能像之前那样留到单独调度的 Keepalive goroutine 里再设置
+	ws.SetPongHandler(func(string) error {
+		if pc.Timeout > 0 {
+			return ws.SetReadDeadline(time.Now().Add(pc.Timeout))
+		}
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// TCP -> WS
+	go func() {
+		defer wg.Done()
+		defer cancel()
+
+		bufp := pipeBufPool.Get().(*[]byte)
+		defer pipeBufPool.Put(bufp)
+		buf := *bufp
+
+		for {
+			n, err := tcp.Read(buf)
+			if n > 0 {
+				if pc.Timeout > 0 {
+					ws.SetWriteDeadline(time.Now().Add(pc.Timeout))
+				}
+				if werr := ws.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					logger.Printf("[%s] WS 写入错误: %v", label, werr)
+					return
+				}
+				BytesTotal.WithLabelValues("tcp_to_ws").Add(float64(n))
+				if stats != nil {
+					atomic.AddInt64(&stats.BytesToWS, int64(n))
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					logger.Printf("[%s] TCP 读取错误: %v", label, err)
+				}
+				return
+			}
+		}
+	}()
+
+	// WS -> TCP
+	go func() {
+		defer wg.Done()
+		defer cancel()
+
+		for {
+			mt, data, err := ws.ReadMessage()
+			if err != nil {
+				logger.Printf("[%s] WS 读取错误: %v", label, err)
+				return
+			}
+			if mt != websocket.BinaryMessage {
+				continue
+			}
+			if pc.Timeout > 0 {
+				tcp.SetWriteDeadline(time.Now().Add(pc.Timeout))
+			}
+			if _, werr := tcp.Write(data); werr != nil {
+				logger.Printf("[%s] TCP 写入错误: %v", label, werr)
+				return
+			}
+			BytesTotal.WithLabelValues("ws_to_tcp").Add(float64(len(data)))
+			if stats != nil {
+				atomic.AddInt64(&stats.BytesToTCP, int64(len(data)))
+			}
+		}
+	}()
+
+	if pc.Interval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Keepalive(ctx, ws, pc)
+		}()
+	}
+
+	<-ctx.Done()
+	// 任意一侧结束后，强制让另一侧阻塞中的 Read 尽快返回，完成“关闭一侧
+	// 即取消另一侧并排空对端”的协调关闭，防止 goroutine 泄漏
+	tcp.SetDeadline(time.Now())
+	ws.SetReadDeadline(time.Now())
+	wg.Wait()
+}
+
+// Keepalive 周期性发送 ping 帧，依赖调用方已经注册好收到 pong 时刷新读超时的
+// PongHandler（见 Pipe），用于防止空闲的 NAT/负载均衡会话被静默断开
+func Keepalive(ctx context.Context, ws *websocket.Conn, pc PingConfig) {
+	ticker := time.NewTicker(pc.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if pc.Timeout > 0 {
+				ws.SetWriteDeadline(time.Now().Add(pc.Timeout))
+			}
+			if err := ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}